@@ -0,0 +1,94 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastUpdated records the wall-clock time of the most recent successful
+// updatetemplateInformation call. templateFields carries no timestamp of
+// its own, so the JSON API uses this to derive its Last-Modified header.
+var (
+	lastUpdated    time.Time
+	lastUpdatedMtx sync.RWMutex
+)
+
+func setLastUpdated(t time.Time) {
+	lastUpdatedMtx.Lock()
+	lastUpdated = t
+	lastUpdatedMtx.Unlock()
+}
+
+func getLastUpdated() time.Time {
+	lastUpdatedMtx.RLock()
+	defer lastUpdatedMtx.RUnlock()
+	return lastUpdated
+}
+
+// writeJSON encodes v as the response body, setting an ETag derived from the
+// current BlockHeight and a Last-Modified header from lastUpdated, so that
+// dashboards and bots can cheaply poll without re-fetching unchanged data.
+// Callers must already hold templateInformationMtx (for reading), since the
+// ETag is read from templateInformation whatever v is.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	etag := fmt.Sprintf(`"%d"`, templateInformation.BlockHeight)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", getLastUpdated().UTC().Format(http.TimeFormat))
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Failed to encode JSON response:", err)
+	}
+}
+
+// apiStatus handles GET /api/v1/status, returning the same templateFields
+// used to render the HTML demo page as JSON.
+func apiStatus(w http.ResponseWriter, r *http.Request) {
+	templateInformationMtx.RLock()
+	defer templateInformationMtx.RUnlock()
+
+	writeJSON(w, r, templateInformation)
+}
+
+// apiAgenda handles GET /api/v1/agendas/{id}, returning a single agenda by
+// its ID, or a 404 if it's not part of the current Agendas slice.
+func apiAgenda(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/agendas/")
+	if id == "" {
+		http.Error(w, "missing agenda id", http.StatusBadRequest)
+		return
+	}
+
+	templateInformationMtx.RLock()
+	defer templateInformationMtx.RUnlock()
+
+	for _, agenda := range templateInformation.Agendas {
+		if agenda.Id == id {
+			writeJSON(w, r, agenda)
+			return
+		}
+	}
+
+	http.Error(w, "agenda not found", http.StatusNotFound)
+}
+
+// registerAPIHandlers wires up the JSON API endpoints on the default
+// http.ServeMux so dashboards, bots, and monitoring integrations can
+// consume fork progress without scraping the HTML page.
+func registerAPIHandlers() {
+	http.HandleFunc("/api/v1/status", apiStatus)
+	http.HandleFunc("/api/v1/agendas/", apiAgenda)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/karamble/hardforkdemo/store"
+)
+
+// defaultHistoryPoints bounds how many points a history request returns
+// when the caller doesn't ask for a specific resolution, keeping chart
+// payloads small regardless of how long the demo has been running.
+const defaultHistoryPoints = 500
+
+// historyRange parses the optional from/to/points query parameters shared
+// by the history endpoints, defaulting to the full recorded history
+// downsampled to defaultHistoryPoints. The caller must already hold
+// templateInformationMtx for reading.
+func historyRange(r *http.Request) (from, to int64, points int) {
+	from, to = 0, templateInformation.BlockHeight
+	points = defaultHistoryPoints
+
+	if v := r.URL.Query().Get("from"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			from = parsed
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			to = parsed
+		}
+	}
+	if v := r.URL.Query().Get("points"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			points = parsed
+		}
+	}
+
+	return from, to, points
+}
+
+// apiHistoryBlockVersions handles GET /api/v1/history/blockversions,
+// returning a downsampled series of per-height block version counts.
+func apiHistoryBlockVersions(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "history is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	templateInformationMtx.RLock()
+	from, to, points := historyRange(r)
+	templateInformationMtx.RUnlock()
+
+	snapshots, err := dataStore.Range(from, to)
+	if err != nil {
+		http.Error(w, "failed to read history", http.StatusInternalServerError)
+		return
+	}
+
+	templateInformationMtx.RLock()
+	defer templateInformationMtx.RUnlock()
+	writeJSON(w, r, store.Downsample(snapshots, points))
+}
+
+// apiHistoryAgenda handles GET /api/v1/history/agendas/{id}, returning a
+// downsampled series of a single agenda's quorum progress over time.
+func apiHistoryAgenda(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "history is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/history/agendas/")
+	if id == "" {
+		http.Error(w, "missing agenda id", http.StatusBadRequest)
+		return
+	}
+
+	templateInformationMtx.RLock()
+	from, to, points := historyRange(r)
+	templateInformationMtx.RUnlock()
+
+	snapshots, err := dataStore.Range(from, to)
+	if err != nil {
+		http.Error(w, "failed to read history", http.StatusInternalServerError)
+		return
+	}
+
+	progress := make([]store.Snapshot, 0, len(snapshots))
+	for _, snap := range snapshots {
+		if _, ok := snap.AgendaProgress[id]; ok {
+			progress = append(progress, snap)
+		}
+	}
+
+	templateInformationMtx.RLock()
+	defer templateInformationMtx.RUnlock()
+	writeJSON(w, r, store.Downsample(progress, points))
+}
+
+// registerHistoryHandlers wires up the history API endpoints backed by
+// dataStore.
+func registerHistoryHandlers() {
+	http.HandleFunc("/api/v1/history/blockversions", apiHistoryBlockVersions)
+	http.HandleFunc("/api/v1/history/agendas/", apiHistoryAgenda)
+}
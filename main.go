@@ -19,8 +19,11 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrjson"
 	"github.com/decred/dcrd/wire"
 	"github.com/decred/dcrrpcclient"
+	"github.com/karamble/hardforkdemo/store"
 )
 
 // Set some high value to check version number
@@ -33,12 +36,62 @@ var pass = flag.String("pass", "PASSWORD", "node RPC password")
 var cert = flag.String("cert", "/home/user/.dcrd/rpc.cert", "node RPC TLS certificate (when notls=false)")
 var notls = flag.Bool("notls", false, "Disable use of TLS for node connection")
 var listenPort = flag.String("listen", ":8000", "web app listening port")
+var datadir = flag.String("datadir", "./data", "directory to store the upgrade progress history database")
+var network = flag.String("network", "mainnet", "network to use {mainnet, testnet, simnet}")
+
+// dataStore persists a time-series of upgrade progress so the demo can
+// chart history as well as the current block, and survives restarts.
+var dataStore *store.Store
+
+// startupBackfillAttempted guards the one-time attempt to populate the
+// block version rolling window from dataStore instead of from dcrd,
+// avoiding a GetStakeVersions(BlockUpgradeNumToCheck*2) request on the very
+// first updatetemplateInformation call after the process starts.
+var startupBackfillAttempted bool
+
+// backfillRollingWindow tries to populate templateInformation's block
+// version rolling window chart directly from dataStore's history ending at
+// tipHeight, returning true on success. It returns false if no store is
+// configured or there isn't yet a full window's worth of history, in which
+// case the caller falls back to rebuilding the window from dcrd.
+func backfillRollingWindow(tipHeight int64, params *chaincfg.Params) bool {
+	if dataStore == nil {
+		return false
+	}
+
+	windowLen := int64(params.BlockUpgradeNumToCheck)
+	snapshots, err := dataStore.Range(tipHeight-windowLen+1, tipHeight)
+	if err != nil {
+		fmt.Println("Failed to read upgrade progress history:", err)
+		return false
+	}
+	if int64(len(snapshots)) < windowLen {
+		return false
+	}
+
+	blockVersionsFound := make(map[int32]*blockVersions)
+	blockVersionsHeights := make([]int64, windowLen)
+	for i, snap := range snapshots {
+		blockVersionsHeights[i] = snap.Height
+		for version, count := range snap.BlockVersionCounts {
+			theseBlockVersions, ok := blockVersionsFound[version]
+			if !ok {
+				theseBlockVersions = &blockVersions{RollingWindowLookBacks: make([]int, windowLen)}
+				blockVersionsFound[version] = theseBlockVersions
+			}
+			theseBlockVersions.RollingWindowLookBacks[i] = int(count)
+		}
+	}
 
-// Daemon Params to use
-var activeNetParams = &chaincfg.MainNetParams
+	templateInformationMtx.Lock()
+	templateInformation.BlockVersionsHeights = blockVersionsHeights
+	templateInformation.BlockVersions = blockVersionsFound
+	templateInformationMtx.Unlock()
+	return true
+}
 
 // Contains a certain block version's count of blocks in the
-// rolling window (which has a length of activeNetParams.BlockUpgradeNumToCheck)
+// rolling window (which has a length of params.BlockUpgradeNumToCheck)
 type blockVersions struct {
 	RollingWindowLookBacks []int
 }
@@ -48,26 +101,56 @@ type intervalVersionCounts struct {
 	Count   []uint32
 }
 
-// Set all activeNetParams fields since they don't change at runtime
-var templateInformation = &templateFields{
-	// BlockVersion params
-	BlockVersionEnforceThreshold: int(float64(activeNetParams.BlockEnforceNumRequired) /
-		float64(activeNetParams.BlockUpgradeNumToCheck) * 100),
-	BlockVersionRejectThreshold: int(float64(activeNetParams.BlockRejectNumRequired) /
-		float64(activeNetParams.BlockUpgradeNumToCheck) * 100),
-	BlockVersionWindowLength: activeNetParams.BlockUpgradeNumToCheck,
-	// StakeVersion params
-	StakeVersionWindowLength: activeNetParams.StakeVersionInterval,
-	StakeVersionThreshold: toFixed(float64(activeNetParams.StakeMajorityMultiplier)/
-		float64(activeNetParams.StakeMajorityDivisor)*100, 0),
-	// RuleChange params
-	RuleChangeActivationQuorum: activeNetParams.RuleChangeActivationQuorum,
-	QuorumThreshold: float64(activeNetParams.RuleChangeActivationQuorum) /
-		float64(activeNetParams.RuleChangeActivationInterval*uint32(activeNetParams.TicketsPerBlock)) * 100,
+// templateInformation is populated by newTemplateInformation once the
+// network to run against is known, and updated in place on every block.
+var templateInformation *templateFields
+
+// templateInformationMtx guards every read or write of templateInformation
+// (including the slices and maps reachable from it), since it's updated in
+// place by a single goroutine while the HTML, JSON API, history, and SSE
+// handlers all read it concurrently from their own goroutines.
+var templateInformationMtx sync.RWMutex
+
+// newTemplateInformation sets the templateFields whose values are fixed
+// for the lifetime of the process, computed from params rather than a
+// hard-coded network so the demo can target mainnet, testnet, or simnet
+// via --network without a recompile.
+func newTemplateInformation(params *chaincfg.Params) *templateFields {
+	return &templateFields{
+		// BlockVersion params
+		BlockVersionEnforceThreshold: int(float64(params.BlockEnforceNumRequired) /
+			float64(params.BlockUpgradeNumToCheck) * 100),
+		BlockVersionRejectThreshold: int(float64(params.BlockRejectNumRequired) /
+			float64(params.BlockUpgradeNumToCheck) * 100),
+		BlockVersionWindowLength: params.BlockUpgradeNumToCheck,
+		// StakeVersion params
+		StakeVersionWindowLength: params.StakeVersionInterval,
+		StakeVersionThreshold: toFixed(float64(params.StakeMajorityMultiplier)/
+			float64(params.StakeMajorityDivisor)*100, 0),
+		// RuleChange params
+		RuleChangeActivationQuorum: params.RuleChangeActivationQuorum,
+		QuorumThreshold: float64(params.RuleChangeActivationQuorum) /
+			float64(params.RuleChangeActivationInterval*uint32(params.TicketsPerBlock)) * 100,
+	}
+}
+
+// netParamsForName maps a --network flag value to the corresponding
+// chaincfg.Params.
+func netParamsForName(name string) (*chaincfg.Params, error) {
+	switch name {
+	case "mainnet":
+		return &chaincfg.MainNetParams, nil
+	case "testnet":
+		return &chaincfg.TestNetParams, nil
+	case "simnet":
+		return &chaincfg.SimNetParams, nil
+	default:
+		return nil, fmt.Errorf("unknown network %q, must be one of mainnet, testnet, simnet", name)
+	}
 }
 
 // updatetemplateInformation is called on startup and upon every block connected notification received.
-func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
+func updatetemplateInformation(dcrdClient *dcrrpcclient.Client, params *chaincfg.Params) {
 	fmt.Println("updating hard fork information")
 
 	// Get the current best block (height and hash)
@@ -77,7 +160,9 @@ func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
 		return
 	}
 	// Set Current block height
+	templateInformationMtx.Lock()
 	templateInformation.BlockHeight = height
+	templateInformationMtx.Unlock()
 
 	// Request the current block header
 	blockHeader, err := dcrdClient.GetBlockHeader(hash)
@@ -87,55 +172,95 @@ func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
 	}
 	// Request GetStakeVersions to receive information about past block versions.
 	//
-	// Request twice as many, so we can populate the rolling block version window's first
-	stakeVersionResults, err := dcrdClient.GetStakeVersions(hash.String(),
-		int32(activeNetParams.BlockUpgradeNumToCheck*2))
+	// Normally we request twice as many, so we can populate the rolling block
+	// version window's first half. But on the very first call of a process
+	// that has a populated dataStore, the window can instead be backfilled
+	// from history, so only the most recent window's worth is needed.
+	fetchCount := params.BlockUpgradeNumToCheck * 2
+	skipChartRebuild := false
+	if !startupBackfillAttempted {
+		startupBackfillAttempted = true
+		if backfillRollingWindow(height, params) {
+			fetchCount = params.BlockUpgradeNumToCheck
+			skipChartRebuild = true
+		}
+	}
+
+	stakeVersionResults, err := dcrdClient.GetStakeVersions(hash.String(), int32(fetchCount))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	blockVersionsFound := make(map[int32]*blockVersions)
-	blockVersionsHeights := make([]int64, activeNetParams.BlockUpgradeNumToCheck)
-	elementNum := 0
-
-	// The algorithm starts at the middle of the GetStakeVersionResults and decrements backwards toward
-	// the beginning of the list.  This is due to GetStakeVersionResults.StakeVersions being ordered
-	// from most recent blocks to oldest. (ie [0] == current, [len] == oldest).  So by starting in the middle
-	// we then can calculate that first blocks rolling window result then become one block 'more recent'
-	// and calculate that blocks rolling window results.
-	for i := len(stakeVersionResults.StakeVersions)/2 - 1; i >= 0; i-- {
-		// Calculate the last block element in the window
-		windowEnd := i + int(activeNetParams.BlockUpgradeNumToCheck)
-		// blockVersionsHeights lets us have a correctly ordered list of blockheights for xaxis label
-		blockVersionsHeights[elementNum] = stakeVersionResults.StakeVersions[i].Height
-		// Define rolling window range for this current block (i)
-		stakeVersionsWindow := stakeVersionResults.StakeVersions[i:windowEnd]
-		for _, stakeVersion := range stakeVersionsWindow {
-			// Try to get an existing blockVersions struct (pointer)
-			theseBlockVersions, ok := blockVersionsFound[stakeVersion.BlockVersion]
-			if !ok {
-				// Had not found this block version yet
-				theseBlockVersions = &blockVersions{}
-				blockVersionsFound[stakeVersion.BlockVersion] = theseBlockVersions
-				theseBlockVersions.RollingWindowLookBacks =
-					make([]int, activeNetParams.BlockUpgradeNumToCheck)
-				// Need to populate "back" to fill in values for previously missed window
-				for k := 0; k < elementNum; k++ {
-					theseBlockVersions.RollingWindowLookBacks[k] = 0
+	if len(stakeVersionResults.StakeVersions) > 0 {
+		cacheHeader(height, stakeVersionResults.StakeVersions[0].BlockVersion)
+	}
+
+	if !skipChartRebuild {
+		blockVersionsFound := make(map[int32]*blockVersions)
+		blockVersionsHeights := make([]int64, params.BlockUpgradeNumToCheck)
+		elementNum := 0
+
+		// The algorithm starts at the middle of the GetStakeVersionResults and decrements backwards toward
+		// the beginning of the list.  This is due to GetStakeVersionResults.StakeVersions being ordered
+		// from most recent blocks to oldest. (ie [0] == current, [len] == oldest).  So by starting in the middle
+		// we then can calculate that first blocks rolling window result then become one block 'more recent'
+		// and calculate that blocks rolling window results.
+		for i := len(stakeVersionResults.StakeVersions)/2 - 1; i >= 0; i-- {
+			// Calculate the last block element in the window
+			windowEnd := i + int(params.BlockUpgradeNumToCheck)
+			// blockVersionsHeights lets us have a correctly ordered list of blockheights for xaxis label
+			blockVersionsHeights[elementNum] = stakeVersionResults.StakeVersions[i].Height
+			// Define rolling window range for this current block (i)
+			stakeVersionsWindow := stakeVersionResults.StakeVersions[i:windowEnd]
+			for _, stakeVersion := range stakeVersionsWindow {
+				// Try to get an existing blockVersions struct (pointer)
+				theseBlockVersions, ok := blockVersionsFound[stakeVersion.BlockVersion]
+				if !ok {
+					// Had not found this block version yet
+					theseBlockVersions = &blockVersions{}
+					blockVersionsFound[stakeVersion.BlockVersion] = theseBlockVersions
+					theseBlockVersions.RollingWindowLookBacks =
+						make([]int, params.BlockUpgradeNumToCheck)
+					// Need to populate "back" to fill in values for previously missed window
+					for k := 0; k < elementNum; k++ {
+						theseBlockVersions.RollingWindowLookBacks[k] = 0
+					}
+					theseBlockVersions.RollingWindowLookBacks[elementNum] = 1
+				} else {
+					// Already had that block version, so increment
+					theseBlockVersions.RollingWindowLookBacks[elementNum]++
 				}
-				theseBlockVersions.RollingWindowLookBacks[elementNum] = 1
-			} else {
-				// Already had that block version, so increment
-				theseBlockVersions.RollingWindowLookBacks[elementNum]++
 			}
+			elementNum++
 		}
-		elementNum++
+		templateInformationMtx.Lock()
+		templateInformation.BlockVersionsHeights = blockVersionsHeights
+		templateInformation.BlockVersions = blockVersionsFound
+		templateInformationMtx.Unlock()
 	}
-	templateInformation.BlockVersionsHeights = blockVersionsHeights
-	templateInformation.BlockVersions = blockVersionsFound
 
+	updateStakeAndVoteState(dcrdClient, hash, height, blockHeader, stakeVersionResults, params)
+}
+
+// updateStakeAndVoteState recomputes the current block version window
+// counts plus everything derived from stake version and vote state:
+// voting interval tallies, GetVoteInfo, agendas, and the resulting
+// history snapshot and SSE broadcast. It's factored out of
+// updatetemplateInformation so that handleBlockDisconnected can re-run it
+// against a freshly fetched tip without repeating the expensive rolling
+// window chart rebuild above.
+//
+// All of the dcrd RPC calls and computation below run against locals, with
+// no lock held, so a slow vote-version lookup or stake version query can't
+// block every HTML/JSON/SSE reader of templateInformation for its duration.
+// templateInformationMtx is only taken right at the end, to publish the
+// results, and is held across the broadcastUpdate call at that point, so
+// broadcastUpdate assumes the lock is already held rather than taking it
+// itself.
+func updateStakeAndVoteState(dcrdClient *dcrrpcclient.Client, hash *chainhash.Hash, height int64,
+	blockHeader *wire.BlockHeader, stakeVersionResults *dcrjson.GetStakeVersionsResult, params *chaincfg.Params) {
 	// Pick min block version (current version) out of most recent window
-	stakeVersionsWindow := stakeVersionResults.StakeVersions[:activeNetParams.BlockUpgradeNumToCheck]
+	stakeVersionsWindow := stakeVersionResults.StakeVersions[:params.BlockUpgradeNumToCheck]
 	blockVersionsCounts := make(map[int32]int64)
 	for _, sv := range stakeVersionsWindow {
 		blockVersionsCounts[sv.BlockVersion] = blockVersionsCounts[sv.BlockVersion] + 1
@@ -158,24 +283,16 @@ func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
 	}
 
 	blockWinUpgradePct := func(count int64) float64 {
-		return 100 * float64(count) / float64(activeNetParams.BlockUpgradeNumToCheck)
+		return 100 * float64(count) / float64(params.BlockUpgradeNumToCheck)
 	}
 
-	templateInformation.BlockVersionCurrent = minBlockVersion
-
-	templateInformation.BlockVersionMostPopular = popBlockVersion
-	templateInformation.BlockVersionMostPopularPercentage = toFixed(blockWinUpgradePct(popBlockVersionCount), 2)
-
-	templateInformation.BlockVersionNext = minBlockVersion + 1
-	templateInformation.BlockVersionNextPercentage = toFixed(blockWinUpgradePct(blockVersionsCounts[minBlockVersion+1]), 2)
-
-	if popBlockVersionCount > int64(activeNetParams.BlockEnforceNumRequired) {
-		templateInformation.BlockVersionSuccess = true
-	}
+	blockVersionMostPopularPercentage := toFixed(blockWinUpgradePct(popBlockVersionCount), 2)
+	blockVersionNextPercentage := toFixed(blockWinUpgradePct(blockVersionsCounts[minBlockVersion+1]), 2)
+	blockVersionSuccess := popBlockVersionCount > int64(params.BlockEnforceNumRequired)
 
 	// Voting intervals ((height-4096) mod 2016)
-	blocksIntoStakeVersionInterval := (height - activeNetParams.StakeValidationHeight) %
-		activeNetParams.StakeVersionInterval
+	blocksIntoStakeVersionInterval := (height - params.StakeValidationHeight) %
+		params.StakeVersionInterval
 	// Stake versions per block in current voting interval (getstakeversions hash blocksIntoInterval)
 	intervalStakeVersions, err := dcrdClient.GetStakeVersions(hash.String(),
 		int32(blocksIntoStakeVersionInterval))
@@ -185,7 +302,7 @@ func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
 	// Tally missed votes so far in this interval
 	missedVotesStakeInterval := 0
 	for _, stakeVersionResult := range intervalStakeVersions.StakeVersions {
-		missedVotesStakeInterval += int(activeNetParams.TicketsPerBlock) - len(stakeVersionResult.Votes)
+		missedVotesStakeInterval += int(params.TicketsPerBlock) - len(stakeVersionResult.Votes)
 	}
 
 	// Vote tallies for previous intervals (getstakeversioninfo 4)
@@ -200,7 +317,6 @@ func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
 		fmt.Println("StakeVersion info did not return usable information, intervals empty")
 		return
 	}
-	templateInformation.StakeVersionsIntervals = stakeVersionInfo.Intervals
 
 	minimumNeededVoteVersions := uint32(100)
 	// Hacky way of populating the Vote Version bar graph
@@ -235,14 +351,9 @@ func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
 	stakeVersionLabels[numIntervals-1] = "Current Interval"
 	currentInterval := stakeVersionInfo.Intervals[0]
 
-	maxPossibleVotes := activeNetParams.StakeVersionInterval*int64(activeNetParams.TicketsPerBlock) -
+	maxPossibleVotes := params.StakeVersionInterval*int64(params.TicketsPerBlock) -
 		int64(missedVotesStakeInterval)
 
-	templateInformation.StakeVersionIntervalResults = stakeVersionIntervalResults
-	templateInformation.StakeVersionWindowVoteTotal = maxPossibleVotes
-	templateInformation.StakeVersionIntervalLabels = stakeVersionLabels
-	templateInformation.StakeVersionCurrent = blockHeader.StakeVersion
-
 	var mostPopularVersion, mostPopularVersionCount uint32
 	for _, stakeVersion := range currentInterval.VoteVersions {
 		if stakeVersion.Version > blockHeader.StakeVersion &&
@@ -252,73 +363,225 @@ func updatetemplateInformation(dcrdClient *dcrrpcclient.Client) {
 		}
 	}
 
-	templateInformation.StakeVersionMostPopularCount = mostPopularVersionCount
-	templateInformation.StakeVersionMostPopularPercentage = toFixed(float64(mostPopularVersionCount)/
+	stakeVersionMostPopularPercentage := toFixed(float64(mostPopularVersionCount)/
 		float64(maxPossibleVotes)*100, 2)
-	templateInformation.StakeVersionMostPopular = mostPopularVersion
-	templateInformation.StakeVersionRequiredVotes = int32(maxPossibleVotes) *
-		activeNetParams.StakeMajorityMultiplier / activeNetParams.StakeMajorityDivisor
-	if int32(mostPopularVersionCount) > templateInformation.StakeVersionRequiredVotes {
-		templateInformation.StakeVersionSuccess = true
-	}
+	stakeVersionRequiredVotes := int32(maxPossibleVotes) *
+		params.StakeMajorityMultiplier / params.StakeMajorityDivisor
+	stakeVersionSuccess := int32(mostPopularVersionCount) > stakeVersionRequiredVotes
 
 	blocksIntoInterval := currentInterval.EndHeight - currentInterval.StartHeight
-	templateInformation.StakeVersionVotesRemaining =
-		(activeNetParams.StakeVersionInterval - blocksIntoInterval) * int64(activeNetParams.TicketsPerBlock)
+	stakeVersionVotesRemaining :=
+		(params.StakeVersionInterval - blocksIntoInterval) * int64(params.TicketsPerBlock)
 
 	// Quorum/vote information
 	getVoteInfo, err := dcrdClient.GetVoteInfo(mostPopularVersion)
 	if err != nil {
 		fmt.Println("Get vote info err", err)
+		templateInformationMtx.Lock()
 		templateInformation.Quorum = false
-		return
-	}
-	templateInformation.GetVoteInfoResult = getVoteInfo
-
-	// There may be no agendas for this vote version
-	if len(getVoteInfo.Agendas) == 0 {
-		fmt.Printf("No agendas for vote version %d\n", mostPopularVersion)
-		templateInformation.Agendas = []Agenda{}
+		templateInformationMtx.Unlock()
 		return
 	}
 
 	// Set Quorum to true since we got a valid response back from GetVoteInfoResult (?)
-	if getVoteInfo.TotalVotes >= getVoteInfo.Quorum {
-		templateInformation.Quorum = true
-	}
+	quorum := getVoteInfo.TotalVotes >= getVoteInfo.Quorum
 
 	// Status LockedIn Circle3 Ring Indicates BlocksLeft until old versions gets denied
 	lockedinBlocksleft := float64(getVoteInfo.EndHeight) - float64(getVoteInfo.CurrentHeight)
 	lockedinWindowsize := float64(getVoteInfo.EndHeight) - float64(getVoteInfo.StartHeight)
 	lockedinPercentage := lockedinWindowsize / 100
 
+	// mostPopularVersion alone hides agendas that belong to older or
+	// lower-count vote versions which may still be in progress, so walk
+	// every version observed across the full interval history instead.
+	agendas, err := agendasForVersions(dcrdClient, stakeVersionInfo.Intervals, blockHeader.StakeVersion)
+	if err != nil {
+		fmt.Println("agendasForVersions err", err)
+		return
+	}
+
+	templateInformationMtx.Lock()
+	templateInformation.BlockVersionCurrent = minBlockVersion
+	templateInformation.BlockVersionMostPopular = popBlockVersion
+	templateInformation.BlockVersionMostPopularPercentage = blockVersionMostPopularPercentage
+	templateInformation.BlockVersionNext = minBlockVersion + 1
+	templateInformation.BlockVersionNextPercentage = blockVersionNextPercentage
+	if blockVersionSuccess {
+		templateInformation.BlockVersionSuccess = true
+	}
+	templateInformation.StakeVersionsIntervals = stakeVersionInfo.Intervals
+	templateInformation.StakeVersionIntervalResults = stakeVersionIntervalResults
+	templateInformation.StakeVersionWindowVoteTotal = maxPossibleVotes
+	templateInformation.StakeVersionIntervalLabels = stakeVersionLabels
+	templateInformation.StakeVersionCurrent = blockHeader.StakeVersion
+	templateInformation.StakeVersionMostPopularCount = mostPopularVersionCount
+	templateInformation.StakeVersionMostPopularPercentage = stakeVersionMostPopularPercentage
+	templateInformation.StakeVersionMostPopular = mostPopularVersion
+	templateInformation.StakeVersionRequiredVotes = stakeVersionRequiredVotes
+	if stakeVersionSuccess {
+		templateInformation.StakeVersionSuccess = true
+	}
+	templateInformation.StakeVersionVotesRemaining = stakeVersionVotesRemaining
+	templateInformation.GetVoteInfoResult = getVoteInfo
+	templateInformation.Quorum = quorum
 	templateInformation.LockedinPercentage = toFixed(lockedinBlocksleft/lockedinPercentage, 2)
-	templateInformation.Agendas = make([]Agenda, 0, len(getVoteInfo.Agendas))
-
-	for i := range getVoteInfo.Agendas {
-		choiceIds := make([]string, len(getVoteInfo.Agendas[i].Choices))
-		choicePercentages := make([]float64, len(getVoteInfo.Agendas[i].Choices))
-		for i, choice := range getVoteInfo.Agendas[i].Choices {
-			if !choice.IsAbstain {
-				choiceIds[i] = choice.Id
-				choicePercentages[i] = toFixed(choice.Progress*100, 2)
+	templateInformation.Agendas = agendas
+
+	setLastUpdated(time.Now())
+	broadcastUpdate()
+	templateInformationMtx.Unlock()
+
+	recordSnapshot(height, blockVersionsCounts, stakeVersionIntervalResults, agendas)
+}
+
+// recordSnapshot appends the block's computed state to dataStore, if one
+// was successfully opened at startup. Failures are logged and otherwise
+// ignored, since history is a bonus on top of the live "now" view rather
+// than something the demo depends on to function.
+func recordSnapshot(height int64, blockVersionsCounts map[int32]int64,
+	stakeVersionIntervalResults []intervalVersionCounts, agendas []Agenda) {
+	if dataStore == nil {
+		return
+	}
+
+	stakeVersionIntervalCounts := make(map[uint32][]uint32, len(stakeVersionIntervalResults))
+	for _, result := range stakeVersionIntervalResults {
+		stakeVersionIntervalCounts[result.Version] = result.Count
+	}
+
+	agendaProgress := make(map[string]float64, len(agendas))
+	for _, agenda := range agendas {
+		agendaProgress[agenda.Id] = agenda.QuorumVotedPercentage
+	}
+
+	snap := store.Snapshot{
+		Height:                     height,
+		Timestamp:                  time.Now().Unix(),
+		BlockVersionCounts:         blockVersionsCounts,
+		StakeVersionIntervalCounts: stakeVersionIntervalCounts,
+		AgendaProgress:             agendaProgress,
+	}
+	if err := dataStore.AppendSnapshot(snap); err != nil {
+		fmt.Println("Failed to record upgrade progress snapshot:", err)
+	}
+}
+
+// Agenda wraps a dcrjson.Agenda with the display fields the template needs
+// and the vote version it was observed under. When the same agenda ID is
+// reported by more than one version, agendasForVersions keeps only the
+// entry from the lowest of those versions.
+type Agenda struct {
+	dcrjson.Agenda
+	VoteVersion               uint32
+	QuorumExpirationDate      string
+	QuorumVotedPercentage     float64
+	QuorumAbstainedPercentage float64
+	ChoiceIDs                 []string
+	ChoicePercentages         []float64
+	StartHeight               int64
+}
+
+// voteInfoCache holds GetVoteInfo results for vote versions dcrd has fully
+// retired (anything below the tip's current stake version can no longer be
+// voted on, so its tallies are frozen), keyed by version. This saves a
+// synchronous RPC round trip per retired version on every single block.
+var (
+	voteInfoCacheMtx sync.Mutex
+	voteInfoCache    = make(map[uint32]*dcrjson.GetVoteInfoResult)
+)
+
+// agendasForVersions walks every vote version observed across the supplied
+// stake version intervals - from the lowest to the highest - querying
+// GetVoteInfo for each and merging the results into a single slice of
+// Agendas, deduplicated by agenda ID and tagged with the vote version they
+// came from. This mirrors the approach taken by the successor
+// dcrvotingweb project, since a single GetVoteInfo(mostPopularVersion)
+// call silently drops agendas tied to other simultaneously active
+// versions. currentStakeVersion is the tip's stake version, used to decide
+// which versions are done voting and safe to cache. It runs with no lock
+// held, so it's safe to call while templateInformationMtx is free, letting
+// other goroutines read templateInformation while this walks dcrd.
+func agendasForVersions(dcrdClient *dcrrpcclient.Client, intervals []dcrjson.VersionInterval,
+	currentStakeVersion uint32) ([]Agenda, error) {
+	agendas := make([]Agenda, 0)
+
+	var minVersion, maxVersion uint32 = math.MaxUint32, 0
+	for _, interval := range intervals {
+		for _, voteVersion := range interval.VoteVersions {
+			if voteVersion.Version < minVersion {
+				minVersion = voteVersion.Version
+			}
+			if voteVersion.Version > maxVersion {
+				maxVersion = voteVersion.Version
+			}
+		}
+	}
+	if maxVersion == 0 {
+		return agendas, nil
+	}
+
+	seenAgendaIDs := make(map[string]bool)
+	for version := minVersion; version <= maxVersion; version++ {
+		retired := version < currentStakeVersion
+
+		var voteInfo *dcrjson.GetVoteInfoResult
+		if retired {
+			voteInfoCacheMtx.Lock()
+			voteInfo = voteInfoCache[version]
+			voteInfoCacheMtx.Unlock()
+		}
+
+		if voteInfo == nil {
+			var err error
+			voteInfo, err = dcrdClient.GetVoteInfo(version)
+			if err != nil {
+				// Not every version in [minVersion, maxVersion] necessarily
+				// has vote info (eg. versions dcrd has never enforced), so
+				// skip it and keep walking the rest of the range.
+				continue
+			}
+
+			if retired {
+				voteInfoCacheMtx.Lock()
+				voteInfoCache[version] = voteInfo
+				voteInfoCacheMtx.Unlock()
 			}
 		}
 
-		templateInformation.Agendas = append(templateInformation.Agendas, Agenda{
-			Agenda:                    getVoteInfo.Agendas[i],
-			QuorumExpirationDate:      time.Unix(int64(getVoteInfo.Agendas[i].ExpireTime), int64(0)).Format(time.RFC850),
-			QuorumVotedPercentage:     toFixed(getVoteInfo.Agendas[i].QuorumProgress*100, 2),
-			QuorumAbstainedPercentage: toFixed(getVoteInfo.Agendas[i].Choices[0].Progress*100, 2),
-			ChoiceIDs:                 choiceIds,
-			ChoicePercentages:         choicePercentages,
-			StartHeight:               getVoteInfo.StartHeight,
-		})
+		for i := range voteInfo.Agendas {
+			agenda := &voteInfo.Agendas[i]
+			if seenAgendaIDs[agenda.Id] {
+				continue
+			}
+			seenAgendaIDs[agenda.Id] = true
+
+			choiceIds := make([]string, len(agenda.Choices))
+			choicePercentages := make([]float64, len(agenda.Choices))
+			for j, choice := range agenda.Choices {
+				if !choice.IsAbstain {
+					choiceIds[j] = choice.Id
+					choicePercentages[j] = toFixed(choice.Progress*100, 2)
+				}
+			}
+
+			agendas = append(agendas, Agenda{
+				Agenda:                    *agenda,
+				VoteVersion:               version,
+				QuorumExpirationDate:      time.Unix(int64(agenda.ExpireTime), int64(0)).Format(time.RFC850),
+				QuorumVotedPercentage:     toFixed(agenda.QuorumProgress*100, 2),
+				QuorumAbstainedPercentage: toFixed(agenda.Choices[0].Progress*100, 2),
+				ChoiceIDs:                 choiceIds,
+				ChoicePercentages:         choicePercentages,
+				StartHeight:               voteInfo.StartHeight,
+			})
+		}
 	}
+
+	return agendas, nil
 }
 
 // main wraps mainCore, which does all the work, because deferred functions do
-/// not run after os.Exit().
+// / not run after os.Exit().
 func main() {
 	os.Exit(mainCore())
 }
@@ -326,13 +589,30 @@ func main() {
 func mainCore() int {
 	flag.Parse()
 
+	params, err := netParamsForName(*network)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	templateInformation = newTemplateInformation(params)
+
+	// Open the upgrade progress history database. Failure here is non-fatal;
+	// the demo still works, just without history or the startup backfill.
+	dataStore, err = store.Open(*datadir)
+	if err != nil {
+		fmt.Printf("Failed to open upgrade progress store: %s\n", err.Error())
+		dataStore = nil
+	} else {
+		defer dataStore.Close()
+	}
+
 	// Chans for rpccclient notification handlers
 	connectChan := make(chan int64, 100)
+	disconnectChan := make(chan int64, 100)
 	quit := make(chan struct{})
 
 	// Read in current dcrd cert
 	var dcrdCerts []byte
-	var err error
 	if !*notls {
 		dcrdCerts, err = ioutil.ReadFile(*cert)
 		if err != nil {
@@ -354,6 +634,16 @@ func mainCore() int {
 			fmt.Println("got a new block passing it", blockHeader.Height)
 			connectChan <- int64(blockHeader.Height)
 		},
+		OnBlockDisconnected: func(serializedBlockHeader []byte) {
+			var blockHeader wire.BlockHeader
+			errLocal := blockHeader.Deserialize(bytes.NewReader(serializedBlockHeader))
+			if errLocal != nil {
+				fmt.Printf("Failed to deserialize block header: %v\n", errLocal.Error())
+				return
+			}
+			fmt.Println("block disconnected", blockHeader.Height)
+			disconnectChan <- int64(blockHeader.Height)
+		},
 	}
 
 	// dcrrpclient configuration
@@ -379,6 +669,19 @@ func mainCore() int {
 		dcrdClient.Disconnect()
 	}()
 
+	// Refuse to run if dcrd is on a different network than --network
+	// selected, rather than silently computing thresholds for the wrong
+	// chain.
+	currentNet, err := dcrdClient.GetCurrentNet()
+	if err != nil {
+		fmt.Printf("Failed to query dcrd's current network: %s\n", err.Error())
+		return 1
+	}
+	if currentNet != params.Net {
+		fmt.Printf("dcrd is running on %v but --network selected %v\n", currentNet, params.Net)
+		return 1
+	}
+
 	// Subscribe to block notifications
 	if err = dcrdClient.NotifyBlocks(); err != nil {
 		fmt.Printf("Failed to start register daemon rpc client for  "+
@@ -401,7 +704,7 @@ func mainCore() int {
 	}()
 
 	// Run an initial templateInforation update based on current change
-	updatetemplateInformation(dcrdClient)
+	updatetemplateInformation(dcrdClient, params)
 
 	// Run goroutine for notifications
 	var wg sync.WaitGroup
@@ -411,7 +714,9 @@ func mainCore() int {
 			select {
 			case height := <-connectChan:
 				fmt.Printf("Block height %v connected\n", height)
-				updatetemplateInformation(dcrdClient)
+				updatetemplateInformation(dcrdClient, params)
+			case height := <-disconnectChan:
+				handleBlockDisconnected(dcrdClient, height, params)
 			case <-quit:
 				fmt.Printf("Closing hardfork demo.\n")
 				wg.Done()
@@ -429,6 +734,9 @@ func mainCore() int {
 
 	// URL handlers for js/css/fonts/images
 	http.HandleFunc("/", webUI.demoPage)
+	registerAPIHandlers()
+	registerHistoryHandlers()
+	registerSSEHandler()
 	http.Handle("/js/", http.StripPrefix("/js/", http.FileServer(http.Dir("public/js/"))))
 	http.Handle("/css/", http.StripPrefix("/css/", http.FileServer(http.Dir("public/css/"))))
 	http.Handle("/fonts/", http.StripPrefix("/fonts/", http.FileServer(http.Dir("public/fonts/"))))
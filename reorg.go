@@ -0,0 +1,120 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/dcrrpcclient"
+)
+
+// headerCacheSize bounds how many recent block headers (and their
+// contribution to the rolling block version window) are retained, enough
+// to unwind a realistic reorg without falling back to a full rebuild.
+const headerCacheSize = 64
+
+// cachedHeader is a block's contribution to the block version rolling
+// window: the single point in BlockVersionsHeights/BlockVersions it added.
+type cachedHeader struct {
+	height       int64
+	blockVersion int32
+}
+
+var (
+	headerCacheMtx sync.Mutex
+	headerCache    []cachedHeader // oldest to newest, bounded to headerCacheSize
+)
+
+// cacheHeader records height's contribution to the rolling window so it
+// can be subtracted again if the block is later disconnected.
+func cacheHeader(height int64, blockVersion int32) {
+	headerCacheMtx.Lock()
+	defer headerCacheMtx.Unlock()
+
+	headerCache = append(headerCache, cachedHeader{height: height, blockVersion: blockVersion})
+	if len(headerCache) > headerCacheSize {
+		headerCache = headerCache[len(headerCache)-headerCacheSize:]
+	}
+}
+
+// popHeader removes and returns the cached contribution for height, if
+// still present.
+func popHeader(height int64) (cachedHeader, bool) {
+	headerCacheMtx.Lock()
+	defer headerCacheMtx.Unlock()
+
+	for i := len(headerCache) - 1; i >= 0; i-- {
+		if headerCache[i].height == height {
+			ch := headerCache[i]
+			headerCache = append(headerCache[:i], headerCache[i+1:]...)
+			return ch, true
+		}
+	}
+	return cachedHeader{}, false
+}
+
+// handleBlockDisconnected unwinds disconnectedHeight's contribution to the
+// block version rolling window, then re-runs only the stake/vote portion
+// of the update against the new tip. This avoids rebuilding the full
+// rolling window chart (a GetStakeVersions(BlockUpgradeNumToCheck*2) call
+// plus an O(n^2) windowing pass) on every reorg.
+func handleBlockDisconnected(dcrdClient *dcrrpcclient.Client, disconnectedHeight int64, params *chaincfg.Params) {
+	fmt.Printf("Block height %v disconnected\n", disconnectedHeight)
+
+	if dataStore != nil {
+		if err := dataStore.DeleteSnapshot(disconnectedHeight); err != nil {
+			fmt.Println("Failed to delete orphaned upgrade progress snapshot:", err)
+		}
+	}
+
+	_, ok := popHeader(disconnectedHeight)
+	if !ok {
+		fmt.Println("Disconnected block predates the header cache, rolling window may be briefly stale")
+	} else {
+		templateInformationMtx.Lock()
+		if n := len(templateInformation.BlockVersionsHeights); n > 0 &&
+			templateInformation.BlockVersionsHeights[n-1] == disconnectedHeight {
+			lastIdx := n - 1
+			templateInformation.BlockVersionsHeights = templateInformation.BlockVersionsHeights[:lastIdx]
+
+			// Every version's RollingWindowLookBacks is index-aligned with
+			// BlockVersionsHeights, not just the disconnected block's own
+			// version, so all of them have to shrink by one entry to keep
+			// that invariant intact for the next read.
+			for _, versions := range templateInformation.BlockVersions {
+				if lastIdx < len(versions.RollingWindowLookBacks) {
+					versions.RollingWindowLookBacks = versions.RollingWindowLookBacks[:lastIdx]
+				}
+			}
+		}
+		templateInformationMtx.Unlock()
+	}
+
+	hash, height, err := dcrdClient.GetBestBlock()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	templateInformationMtx.Lock()
+	templateInformation.BlockHeight = height
+	templateInformationMtx.Unlock()
+
+	blockHeader, err := dcrdClient.GetBlockHeader(hash)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	stakeVersionResults, err := dcrdClient.GetStakeVersions(hash.String(),
+		int32(params.BlockUpgradeNumToCheck))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	updateStakeAndVoteState(dcrdClient, hash, height, blockHeader, stakeVersionResults, params)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseClientBacklog bounds how many undelivered events a single SSE client
+// may queue, so one stuck browser tab can't block updates to everyone else.
+// Once a client's backlog is full, further events are dropped for that
+// client; the client itself stays subscribed.
+const sseClientBacklog = 8
+
+// sseHeartbeatInterval is how often subscribers receive a comment-only
+// keepalive, so proxies and browsers don't time out an idle connection.
+const sseHeartbeatInterval = 30 * time.Second
+
+// sseEvent is the compact payload broadcast to subscribers of /events on
+// every successful updatetemplateInformation call.
+type sseEvent struct {
+	Height                            int64            `json:"height"`
+	BlockVersionMostPopular           int32            `json:"blockVersionMostPopular"`
+	StakeVersionMostPopularPercentage float64          `json:"stakeVersionMostPopularPercentage"`
+	Agendas                           []sseEventAgenda `json:"agendas"`
+}
+
+type sseEventAgenda struct {
+	ID             string  `json:"id"`
+	Status         string  `json:"status"`
+	QuorumProgress float64 `json:"quorumProgress"`
+}
+
+// sseHub fans a stream of JSON events out to every subscribed browser.
+type sseHub struct {
+	mtx         sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+func newSSEHub() *sseHub {
+	return &sseHub{subscribers: make(map[chan []byte]struct{})}
+}
+
+func (h *sseHub) subscribe() chan []byte {
+	ch := make(chan []byte, sseClientBacklog)
+	h.mtx.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mtx.Unlock()
+	return ch
+}
+
+func (h *sseHub) unsubscribe(ch chan []byte) {
+	h.mtx.Lock()
+	delete(h.subscribers, ch)
+	h.mtx.Unlock()
+}
+
+// broadcast sends data to every subscriber, dropping it for any client
+// whose backlog is already full rather than blocking the update goroutine
+// on a slow browser.
+func (h *sseHub) broadcast(data []byte) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- data:
+		default:
+			fmt.Println("SSE client backlog full, dropping event")
+		}
+	}
+}
+
+// eventHub is the process-wide set of /events subscribers.
+var eventHub = newSSEHub()
+
+// broadcastUpdate packages the latest templateInformation into a compact
+// sseEvent and pushes it to every connected browser. The caller must already
+// hold templateInformationMtx, since broadcastUpdate only ever runs as the
+// tail end of updateStakeAndVoteState.
+func broadcastUpdate() {
+	agendas := make([]sseEventAgenda, 0, len(templateInformation.Agendas))
+	for _, agenda := range templateInformation.Agendas {
+		// QuorumVotedPercentage can exceed 100% well before the agenda's
+		// vote actually concludes, since it only reflects quorum having
+		// been reached rather than the agenda's lifecycle state, so use
+		// the status dcrd itself reports instead of a percentage guess.
+		agendas = append(agendas, sseEventAgenda{
+			ID:             agenda.Id,
+			Status:         string(agenda.Status),
+			QuorumProgress: agenda.QuorumVotedPercentage,
+		})
+	}
+
+	event := sseEvent{
+		Height:                            templateInformation.BlockHeight,
+		BlockVersionMostPopular:           templateInformation.BlockVersionMostPopular,
+		StakeVersionMostPopularPercentage: templateInformation.StakeVersionMostPopularPercentage,
+		Agendas:                           agendas,
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Println("Failed to marshal SSE event:", err)
+		return
+	}
+
+	eventHub.broadcast(data)
+}
+
+// sseHandler handles GET /events, streaming a JSON event to the client
+// every time the hard-fork state updates, plus a periodic heartbeat to
+// keep the connection alive through idle proxies.
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := eventHub.subscribe()
+	defer eventHub.unsubscribe(ch)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case data := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// registerSSEHandler wires up the /events endpoint.
+func registerSSEHandler() {
+	http.HandleFunc("/events", sseHandler)
+}
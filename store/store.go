@@ -0,0 +1,163 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package store persists a rolling time-series of hard-fork upgrade
+// progress so the demo can answer "how did we get here" as well as
+// "where are we now", and so that history survives process restarts.
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+var snapshotsBucket = []byte("snapshots")
+
+// Snapshot is one block-connected observation of upgrade progress. It is
+// intentionally a flat, JSON-friendly record rather than a reference to
+// the live templateFields, since templateFields changes shape as the demo
+// grows while a persisted Snapshot must stay decodable.
+type Snapshot struct {
+	Height                     int64               `json:"height"`
+	Timestamp                  int64               `json:"timestamp"`
+	BlockVersionCounts         map[int32]int64     `json:"blockVersionCounts"`
+	StakeVersionIntervalCounts map[uint32][]uint32 `json:"stakeVersionIntervalCounts"`
+	AgendaProgress             map[string]float64  `json:"agendaProgress"`
+}
+
+// Store wraps a bolt database holding the Snapshot history, keyed by
+// block height so lookups and range scans stay cheap as the chain grows.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open creates or opens the snapshot database under datadir, creating
+// datadir itself if it doesn't already exist. The caller is responsible for
+// calling Close when done.
+func Open(datadir string) (*Store, error) {
+	if err := os.MkdirAll(datadir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+
+	db, err := bolt.Open(datadir+"/hardforkdemo.db", 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(snapshotsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize store buckets: %v", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the underlying database handle.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func heightKey(height int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+// AppendSnapshot stores snap, overwriting any existing entry at the same
+// height (eg. after a reorg re-processes that height).
+func (s *Store) AppendSnapshot(snap Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotsBucket).Put(heightKey(snap.Height), data)
+	})
+}
+
+// DeleteSnapshot removes the snapshot recorded at height, used when a
+// disconnected block's contribution needs to be unwound from history.
+func (s *Store) DeleteSnapshot(height int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotsBucket).Delete(heightKey(height))
+	})
+}
+
+// Range returns every Snapshot with height in [fromHeight, toHeight],
+// oldest first.
+func (s *Store) Range(fromHeight, toHeight int64) ([]Snapshot, error) {
+	// Initialized rather than left nil so that callers serializing the
+	// result as JSON (the history API endpoints) always return an array,
+	// never null, when there's no history in range yet.
+	snapshots := make([]Snapshot, 0)
+
+	// Heights are stored as unsigned keys, so a negative fromHeight would
+	// seek past every entry.
+	if fromHeight < 0 {
+		fromHeight = 0
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(snapshotsBucket).Cursor()
+		for k, v := c.Seek(heightKey(fromHeight)); k != nil; k, v = c.Next() {
+			height := int64(binary.BigEndian.Uint64(k))
+			if height > toHeight {
+				break
+			}
+
+			var snap Snapshot
+			if err := json.Unmarshal(v, &snap); err != nil {
+				return fmt.Errorf("failed to unmarshal snapshot at height %d: %v", height, err)
+			}
+			snapshots = append(snapshots, snap)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// Downsample returns at most maxPoints Snapshots evenly spaced across the
+// supplied slice, always keeping the first and last point, suitable for
+// charting long histories without shipping every block to the browser.
+func Downsample(snapshots []Snapshot, maxPoints int) []Snapshot {
+	if maxPoints <= 0 || len(snapshots) <= maxPoints {
+		return snapshots
+	}
+
+	downsampled := make([]Snapshot, 0, maxPoints)
+	for i := 0; i < maxPoints; i++ {
+		idx := i * (len(snapshots) - 1) / (maxPoints - 1)
+		downsampled = append(downsampled, snapshots[idx])
+	}
+
+	return downsampled
+}
+
+// Latest returns the most recently recorded Snapshot, or ok=false if the
+// store is empty.
+func (s *Store) Latest() (snap Snapshot, ok bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(snapshotsBucket).Cursor()
+		k, v := c.Last()
+		if k == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &snap)
+	})
+	return snap, ok, err
+}